@@ -0,0 +1,16 @@
+//go:build android
+// +build android
+
+package lantern
+
+// newSecretStore should persist Token and StripeToken in the Android
+// Keystore. Doing that from pure Go needs a JNI bridge this package
+// doesn't carry, so until that bridge lands this build falls back to the
+// same locally-keyed file store every other platform uses - which does
+// not give Token/StripeToken real OS-keystore protection. Android
+// bindings that need that now should read a Keystore-wrapped key
+// natively and call NewBaseSessionWithSecretKey (see session.go) instead
+// of NewBaseSession.
+func newSecretStore(configDir string, key [32]byte) secretStore {
+	return newFileSecretStore(configDir, key)
+}
@@ -0,0 +1,16 @@
+//go:build ios
+// +build ios
+
+package lantern
+
+// newSecretStore should persist Token and StripeToken in the iOS Keychain.
+// Doing that from pure Go needs a cgo bridge to Security.framework that
+// this package doesn't carry, so until that bridge lands this build falls
+// back to the same locally-keyed file store every other platform uses -
+// which does not give Token/StripeToken real OS-keychain protection. iOS
+// bindings that need that now should read a Keychain item natively and
+// call NewBaseSessionWithSecretKey (see session.go) instead of
+// NewBaseSession.
+func newSecretStore(configDir string, key [32]byte) secretStore {
+	return newFileSecretStore(configDir, key)
+}
@@ -0,0 +1,14 @@
+//go:build !ios && !android
+// +build !ios,!android
+
+package lantern
+
+// newSecretStore is the default secretStore backend for platforms without
+// a keychain/keystore bridge of their own (see keychain_ios.go,
+// keychain_android.go): the same locally-keyed file store used for the
+// rest of BaseSession's fields, just in its own file. NewBaseSessionWithSecretKey
+// (session.go) works here too: it encrypts Token/StripeToken with a key
+// derived from the caller's secret instead of the one in session.key.
+func newSecretStore(configDir string, key [32]byte) secretStore {
+	return newFileSecretStore(configDir, key)
+}
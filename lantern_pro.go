@@ -1,10 +1,12 @@
 package lantern
 
 import (
+	"context"
+	"errors"
+	"fmt"
+
 	"github.com/getlantern/flashlight/proxied"
 	"github.com/getlantern/pro-server-client/go-client"
-	"github.com/stripe/stripe-go"
-	"strings"
 )
 
 const (
@@ -35,12 +37,21 @@ type Session interface {
 	SetStripePubKey(string)
 	AddPlan(string, string, string, bool, int, int)
 	AddDevice(string, string)
+	PaymentProvider() PaymentProvider
+	PaymentPayload() map[string]string
+	ShowLinkCode(code string, verificationURI string, expiresAt int64, interval int)
+	ConfigDir() string
+	OnRequestCompleted(id string, ok bool, err error)
 }
 
 type proRequest struct {
 	proClient *client.Client
 	user      client.User
 	session   Session
+	// idempotencyKey, when set by the outbox, is reused across retries of
+	// the same queued request so a resend can't double-charge or
+	// double-apply a mutation on the pro-server.
+	idempotencyKey string
 }
 
 type proFunc func(*proRequest) (*client.Response, error)
@@ -81,18 +92,13 @@ func newuser(r *proRequest) (*client.Response, error) {
 }
 
 func purchase(r *proRequest) (*client.Response, error) {
-
-	purchase := client.Purchase{
-		IdempotencyKey: stripe.NewIdempotencyKey(),
-		StripeToken:    r.session.StripeToken(),
-		StripeEmail:    r.session.Email(),
-		Plan:           r.session.Plan(),
-		Currency:       strings.ToLower(r.session.Currency()),
+	backend, ok := paymentBackends[r.session.PaymentProvider()]
+	if !ok {
+		log.Errorf("No payment backend registered for provider %v", r.session.PaymentProvider())
+		return nil, fmt.Errorf("unsupported payment provider: %v", r.session.PaymentProvider())
 	}
-	pubKey := r.session.StripeApiKey()
-	deviceName := r.session.DeviceName()
 
-	return r.proClient.Purchase(r.user, deviceName, pubKey, purchase)
+	return backend.purchase(r)
 }
 
 func requestcode(r *proRequest) (*client.Response, error) {
@@ -110,9 +116,17 @@ func requestcode(r *proRequest) (*client.Response, error) {
 func redeemcode(r *proRequest) (*client.Response, error) {
 	r.user.Code = r.session.DeviceCode()
 	res, err := r.proClient.RedeemLinkCode(r.user, r.session.DeviceName())
-	if err != nil || res.Status != "ok" {
+	switch {
+	case err != nil:
 		log.Errorf("Could not redeem code: %v", err)
-	} else {
+	case res.Status != "ok" && isPendingLinkStatus(res.Error):
+		// Expected while the user hasn't finished linking on another
+		// device yet; LinkDevice's poll loop hits this every interval
+		// for the life of the link, so it's not an error.
+		log.Debugf("Redeem code still pending: %s", res.Error)
+	case res.Status != "ok":
+		log.Errorf("Could not redeem code: %v", res.Error)
+	default:
 		r.session.SetToken(res.User.Auth.Token)
 		r.session.SetUserId(res.User.Auth.ID)
 	}
@@ -136,28 +150,30 @@ func cancel(r *proRequest) (*client.Response, error) {
 	return r.proClient.CancelSubscription(r.user)
 }
 
+// plans fetches the plans on offer from the pro-server. It does not mutate
+// Session; Plans (result.go) turns the response into a PlansResult, and
+// applyPlansResult pushes that into Session for ProRequest's callers.
 func plans(r *proRequest) (*client.Response, error) {
 	res, err := r.proClient.Plans(r.user)
-	if err != nil || len(res.Plans) == 0 {
-		return res, err
-	}
-	r.session.SetStripePubKey(res.PubKey)
-	for _, plan := range res.Plans {
-		var currency string
-		var price int
-		for currency, price = range plan.Price {
-			break
-		}
-		if currency != "" {
-			log.Debugf("Calling add plan with %s currency %s desc: %s best value %t price %d",
-				plan.Id, currency, plan.Description, plan.BestValue, price)
-			r.session.AddPlan(plan.Id, plan.Description, currency, plan.BestValue, plan.Duration.Years, price)
-		}
+	if err != nil {
+		log.Errorf("Could not fetch plans: %v", err)
 	}
-
 	return res, err
 }
 
+func applyPlansResult(session Session, result *PlansResult) {
+	session.SetStripePubKey(result.StripePubKey)
+	for _, plan := range result.Plans {
+		log.Debugf("Calling add plan with %s currency %s desc: %s best value %t price %d",
+			plan.Id, plan.Currency, plan.Description, plan.BestValue, plan.Price)
+		session.AddPlan(plan.Id, plan.Description, plan.Currency, plan.BestValue, plan.Years, plan.Price)
+	}
+}
+
+// userdata fetches the current user's status and devices from the
+// pro-server. It does not mutate Session; GetUserData (result.go) turns the
+// response into a UserData, and applyUserDataResult pushes that into
+// Session for ProRequest's callers.
 func userdata(r *proRequest) (*client.Response, error) {
 	res, err := r.proClient.UserData(r.user)
 	if err != nil {
@@ -165,31 +181,92 @@ func userdata(r *proRequest) (*client.Response, error) {
 		return res, err
 	}
 	log.Debugf("User data: %v", res.User)
-	for _, device := range res.User.Devices {
-		r.session.AddDevice(device.Id, device.Name)
-	}
-	r.session.UserData(res.User.UserStatus, res.User.Expiration, res.User.Subscription, res.User.Email)
 	return res, err
 }
 
-func RemoveDevice(shouldProxy bool, deviceId string, session Session) bool {
-	req, err := newRequest(shouldProxy, session)
-	if err != nil {
-		log.Errorf("Error creating request: %v", err)
-		return false
-	}
-	log.Debugf("Calling user link remove on device %s", deviceId)
-	res, err := req.proClient.UserLinkRemove(req.user, deviceId)
-	if err != nil || res.Status != "ok" {
-		log.Errorf("Error removing device: %v status: %s", err, res.Status)
-		return false
+func applyUserDataResult(session Session, result *UserData) {
+	for _, device := range result.Devices {
+		session.AddDevice(device.Id, device.Name)
 	}
+	session.UserData(result.Status, result.Expiration, result.Subscription, result.Email)
+}
 
-	return true
+// RemoveDevice queues removal of deviceId from the account. Like the other
+// mutating pro-server operations, it's handed to the outbox so it survives
+// a dropped connection instead of silently failing. Unlike them, it is not
+// resumed by ResumeOutbox after a restart: the queued entry is dropped.
+func RemoveDevice(shouldProxy bool, deviceId string, session Session) bool {
+	log.Debugf("Queuing user link remove on device %s", deviceId)
+	run := func(r *proRequest) (*client.Response, error) {
+		return r.proClient.UserLinkRemove(r.user, deviceId)
+	}
+	return enqueueMutatingRequest(shouldProxy, "removedevice", session, run)
 }
 
+// ProRequest is a backwards-compatible shim over the typed Purchase/Plans/
+// GetUserData APIs: it still reports success as a bool and pushes results
+// into Session, so existing gomobile bindings keep working while they
+// migrate to the typed APIs.
+//
+// For purchase/cancel/referral (see mutatingCommands) the bool no longer
+// means "the pro-server confirmed this succeeded" - it means "this was
+// durably queued for retry". The actual outcome arrives later via
+// Session.OnRequestCompleted. This is a deliberate trade of the old
+// synchronous contract for surviving a dropped connection (see outbox.go);
+// callers that need the real result synchronously should call Purchase
+// directly instead of going through ProRequest.
 func ProRequest(shouldProxy bool, command string, session Session) bool {
 
+	log.Debugf("Received a %s pro request", command)
+
+	ctx := context.Background()
+
+	if run, ok := mutatingCommands[command]; ok {
+		return enqueueMutatingRequest(shouldProxy, command, session, run)
+	}
+
+	switch command {
+	case "redeemcode":
+		// Unlike purchase/cancel/referral, this isn't queued: it's a poll
+		// against a short-lived device code, not a one-shot mutation, so
+		// it needs to report authorization_pending/slow_down back to the
+		// caller synchronously rather than disappearing into the outbox.
+		req, err := newRequest(shouldProxy, session)
+		if err != nil {
+			log.Errorf("Error creating new request: %v", err)
+			return false
+		}
+		req.session = session
+		req.proClient.SetLocale(session.Locale())
+
+		res, err := redeemcode(req)
+		if cerr := classifyError(command, res, err); cerr != nil {
+			if errors.Is(cerr, ErrPending) {
+				log.Debugf("Redeem code request pending: %v", cerr)
+			} else {
+				logProRequestError(command, session, cerr)
+			}
+			return false
+		}
+		return true
+	case "plans":
+		result, err := Plans(ctx, shouldProxy, session)
+		if err != nil {
+			logProRequestError(command, session, err)
+			return false
+		}
+		applyPlansResult(session, result)
+		return true
+	case "userdata":
+		result, err := GetUserData(ctx, shouldProxy, session)
+		if err != nil {
+			logProRequestError(command, session, err)
+			return false
+		}
+		applyUserDataResult(session, result)
+		return true
+	}
+
 	req, err := newRequest(shouldProxy, session)
 	if err != nil {
 		log.Errorf("Error creating new request: %v", err)
@@ -199,21 +276,19 @@ func ProRequest(shouldProxy bool, command string, session Session) bool {
 
 	req.proClient.SetLocale(session.Locale())
 
-	log.Debugf("Received a %s pro request", command)
-
 	commands := map[string]proFunc{
 		"newuser":     newuser,
-		"purchase":    purchase,
-		"plans":       plans,
 		"signin":      signin,
-		"redeemcode":  redeemcode,
 		"requestcode": requestcode,
-		"userdata":    userdata,
-		"referral":    referral,
-		"cancel":      cancel,
 	}
 
-	res, err := commands[command](req)
+	proFn, ok := commands[command]
+	if !ok {
+		log.Errorf("Unknown pro request command: %s", command)
+		return false
+	}
+
+	res, err := proFn(req)
 	if err != nil || res.Status != "ok" {
 		log.Errorf("Error making %s request to Pro server: %v response: %v", command, err, res)
 		if res != nil {
@@ -224,3 +299,13 @@ func ProRequest(shouldProxy bool, command string, session Session) bool {
 
 	return true
 }
+
+func logProRequestError(command string, session Session, err error) {
+	log.Errorf("Error making %s request to Pro server: %v", command, err)
+	var serverErr *ServerError
+	if errors.As(err, &serverErr) {
+		session.SetError(command, serverErr.Code)
+		return
+	}
+	session.SetError(command, err.Error())
+}
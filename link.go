@@ -0,0 +1,133 @@
+package lantern
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// defaultPollInterval is the interval LinkDevice starts polling at for the
+// device code being redeemed; slow_down responses lengthen it (see backoff).
+const defaultPollInterval = 5 * time.Second
+
+// verificationURIBase is combined with a device code to build the
+// verification_uri_complete that ShowLinkCode hands to the caller for QR
+// encoding.
+const verificationURIBase = "https://account.getlantern.org/link"
+
+var (
+	// ErrLinkExpired is returned when the device code expires before the
+	// user completes the link on another device.
+	ErrLinkExpired = errors.New("link code expired")
+	// ErrLinkDenied is returned when the user explicitly declines the link
+	// request on another device.
+	ErrLinkDenied = errors.New("link request denied")
+)
+
+// LinkDevice implements the OAuth 2.0 device authorization grant loop
+// against the pro-server: it requests a device code, surfaces it (and a
+// verification_uri_complete suitable for QR encoding) via
+// Session.ShowLinkCode, and then polls redeemcode every defaultPollInterval
+// (lengthened on slow_down) until the user completes the link, the code
+// expires, or ctx is canceled. It does not read a poll interval from the
+// pro-server's response.
+func LinkDevice(ctx context.Context, shouldProxy bool, session Session) bool {
+	req, err := newRequest(shouldProxy, session)
+	if err != nil {
+		log.Errorf("Error creating new request: %v", err)
+		return false
+	}
+	req.session = session
+	req.proClient.SetLocale(session.Locale())
+
+	res, err := requestcode(req)
+	if err != nil || res.Status != "ok" {
+		log.Errorf("Could not start device link: %v", err)
+		if res != nil {
+			session.SetError("requestcode", res.Error)
+		}
+		return false
+	}
+
+	code := res.User.Code
+	expiresAt := time.Unix(res.User.ExpireAt, 0)
+	interval := defaultPollInterval
+	session.ShowLinkCode(code, verificationURIBase+"?code="+code, res.User.ExpireAt, int(interval.Seconds()))
+
+	err = pollForLink(ctx, req, expiresAt, interval)
+	if err != nil {
+		log.Errorf("Device link did not complete: %v", err)
+		session.SetError("redeemcode", err.Error())
+		return false
+	}
+
+	return true
+}
+
+// pollForLink repeatedly redeems the device code until the pro-server
+// reports success, a terminal failure, or ctx/expiresAt is reached. It
+// backs off exponentially (with jitter) on slow_down responses.
+func pollForLink(ctx context.Context, req *proRequest, expiresAt time.Time, interval time.Duration) error {
+	return pollForLinkWith(ctx, req, expiresAt, interval, redeemcode)
+}
+
+// pollForLinkWith is pollForLink with the redeem call injected, so tests
+// can drive the authorization_pending/slow_down/expired_token/access_denied
+// state transitions without making a real pro-server request.
+func pollForLinkWith(ctx context.Context, req *proRequest, expiresAt time.Time, interval time.Duration, redeem proFunc) error {
+	for {
+		if time.Now().After(expiresAt) {
+			return ErrLinkExpired
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+
+		res, err := redeem(req)
+		if err != nil {
+			return err
+		}
+
+		switch res.Error {
+		case "":
+			return nil
+		case "authorization_pending":
+			continue
+		case "slow_down":
+			interval = backoff(interval)
+		case "expired_token":
+			return ErrLinkExpired
+		case "access_denied":
+			return ErrLinkDenied
+		default:
+			return errors.New(res.Error)
+		}
+	}
+}
+
+// isPendingLinkStatus reports whether code is one of the device-flow
+// statuses redeemcode returns while the user hasn't finished linking yet
+// (as opposed to a genuine failure). pollForLink treats these as normal
+// progress, not errors, and so does redeemcode's own logging.
+func isPendingLinkStatus(code string) bool {
+	switch code {
+	case "authorization_pending", "slow_down":
+		return true
+	}
+	return false
+}
+
+// backoff doubles interval (capped at one minute) and adds up to 20% jitter,
+// mirroring the backoff guidance in the OAuth device flow spec.
+func backoff(interval time.Duration) time.Duration {
+	next := interval * 2
+	if next > time.Minute {
+		next = time.Minute
+	}
+	jitter := time.Duration(rand.Int63n(int64(next) / 5))
+	return next + jitter
+}
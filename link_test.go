@@ -0,0 +1,106 @@
+package lantern
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/getlantern/pro-server-client/go-client"
+)
+
+// sequencedRedeem returns a proFunc that returns one response from statuses
+// per call, repeating the last entry once exhausted.
+func sequencedRedeem(statuses ...string) proFunc {
+	i := 0
+	return func(r *proRequest) (*client.Response, error) {
+		status := statuses[i]
+		if i < len(statuses)-1 {
+			i++
+		}
+		if status == "" {
+			return &client.Response{Status: "ok"}, nil
+		}
+		return &client.Response{Status: "error", Error: status}, nil
+	}
+}
+
+func TestPollForLinkWithSuccess(t *testing.T) {
+	redeem := sequencedRedeem("authorization_pending", "authorization_pending", "")
+	err := pollForLinkWith(context.Background(), &proRequest{}, time.Now().Add(time.Minute), time.Millisecond, redeem)
+	if err != nil {
+		t.Fatalf("pollForLinkWith() = %v, want nil", err)
+	}
+}
+
+func TestPollForLinkWithExpiredToken(t *testing.T) {
+	redeem := sequencedRedeem("expired_token")
+	err := pollForLinkWith(context.Background(), &proRequest{}, time.Now().Add(time.Minute), time.Millisecond, redeem)
+	if !errors.Is(err, ErrLinkExpired) {
+		t.Fatalf("pollForLinkWith() = %v, want ErrLinkExpired", err)
+	}
+}
+
+func TestPollForLinkWithAccessDenied(t *testing.T) {
+	redeem := sequencedRedeem("access_denied")
+	err := pollForLinkWith(context.Background(), &proRequest{}, time.Now().Add(time.Minute), time.Millisecond, redeem)
+	if !errors.Is(err, ErrLinkDenied) {
+		t.Fatalf("pollForLinkWith() = %v, want ErrLinkDenied", err)
+	}
+}
+
+func TestPollForLinkWithExpiresAt(t *testing.T) {
+	redeem := sequencedRedeem("authorization_pending")
+	err := pollForLinkWith(context.Background(), &proRequest{}, time.Now().Add(-time.Second), time.Millisecond, redeem)
+	if !errors.Is(err, ErrLinkExpired) {
+		t.Fatalf("pollForLinkWith() = %v, want ErrLinkExpired for an already-past expiresAt", err)
+	}
+}
+
+func TestPollForLinkWithSlowDownIncreasesInterval(t *testing.T) {
+	calls := 0
+	var intervals []time.Duration
+	last := time.Now()
+	redeem := func(r *proRequest) (*client.Response, error) {
+		now := time.Now()
+		intervals = append(intervals, now.Sub(last))
+		last = now
+		calls++
+		if calls < 3 {
+			return &client.Response{Status: "error", Error: "slow_down"}, nil
+		}
+		return &client.Response{Status: "ok"}, nil
+	}
+
+	err := pollForLinkWith(context.Background(), &proRequest{}, time.Now().Add(time.Minute), time.Millisecond, redeem)
+	if err != nil {
+		t.Fatalf("pollForLinkWith() = %v, want nil", err)
+	}
+	if calls != 3 {
+		t.Fatalf("redeem called %d times, want 3", calls)
+	}
+	if len(intervals) != 3 {
+		t.Fatalf("got %d recorded intervals, want 3", len(intervals))
+	}
+	if intervals[2] <= intervals[1] {
+		t.Fatalf("interval after slow_down did not increase: %v -> %v", intervals[1], intervals[2])
+	}
+}
+
+func TestPollForLinkWithContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	redeem := sequencedRedeem("authorization_pending")
+	err := pollForLinkWith(ctx, &proRequest{}, time.Now().Add(time.Minute), time.Millisecond, redeem)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("pollForLinkWith() = %v, want context.Canceled", err)
+	}
+}
+
+func TestPollForLinkWithUnknownErrorIsTerminal(t *testing.T) {
+	redeem := sequencedRedeem("some_unrecognized_code")
+	err := pollForLinkWith(context.Background(), &proRequest{}, time.Now().Add(time.Minute), time.Millisecond, redeem)
+	if err == nil || errors.Is(err, ErrLinkExpired) || errors.Is(err, ErrLinkDenied) {
+		t.Fatalf("pollForLinkWith() = %v, want a plain terminal error", err)
+	}
+}
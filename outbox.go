@@ -0,0 +1,259 @@
+package lantern
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const outboxFileName = "pro-outbox.json"
+
+// outboxMaxBackoff caps the delay between retries of a queued request.
+const outboxMaxBackoff = 5 * time.Minute
+
+// outboxEntry is a durable record of a mutating pro-server request that
+// hasn't yet reached a terminal status.
+type outboxEntry struct {
+	Id             string `json:"id"`
+	Command        string `json:"command"`
+	IdempotencyKey string `json:"idempotencyKey"`
+	Attempts       int    `json:"attempts"`
+}
+
+// outbox is a JSON-file-backed durable queue of mutating pro-server
+// requests, keyed by idempotency key, so a purchase, cancel, referral or
+// removedevice call made while the tunnel is down eventually reconciles once
+// connectivity returns instead of silently being lost. redeemcode is not
+// queued here (see mutatingCommands).
+type outbox struct {
+	mu   sync.Mutex
+	path string
+}
+
+func newOutbox(configDir string) *outbox {
+	return &outbox{path: filepath.Join(configDir, outboxFileName)}
+}
+
+func (o *outbox) load() (map[string]*outboxEntry, error) {
+	entries := make(map[string]*outboxEntry)
+
+	data, err := ioutil.ReadFile(o.path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return entries, nil
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (o *outbox) save(entries map[string]*outboxEntry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(o.path, data, 0600)
+}
+
+func (o *outbox) add(entry *outboxEntry) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entries, err := o.load()
+	if err != nil {
+		return err
+	}
+	entries[entry.Id] = entry
+	return o.save(entries)
+}
+
+func (o *outbox) remove(id string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entries, err := o.load()
+	if err != nil {
+		log.Errorf("Could not load outbox to remove %s: %v", id, err)
+		return
+	}
+	delete(entries, id)
+	if err := o.save(entries); err != nil {
+		log.Errorf("Could not save outbox after removing %s: %v", id, err)
+	}
+}
+
+func (o *outbox) recordAttempt(id string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entries, err := o.load()
+	if err != nil {
+		log.Errorf("Could not load outbox to record attempt for %s: %v", id, err)
+		return
+	}
+	if entry, ok := entries[id]; ok {
+		entry.Attempts++
+		if err := o.save(entries); err != nil {
+			log.Errorf("Could not save outbox after recording attempt for %s: %v", id, err)
+		}
+	}
+}
+
+// mutatingCommands lists the proFuncs that change state on the pro-server
+// and therefore must survive a dropped connection via the outbox, rather
+// than being lost if the in-flight request fails.
+//
+// redeemcode is deliberately not here: it's a poll, not a one-shot mutation
+// - LinkDevice's own pollForLink loop already retries it at the
+// server-provided interval and knows how to tell "authorization_pending"
+// apart from a real failure, and ProRequest calls it synchronously for the
+// same reason (see ProRequest's "redeemcode" case). Queuing it here too
+// would mean two independent retry loops for the same device code, and the
+// outbox's indefinite backoff doesn't know about the code's expiresAt.
+//
+// Idempotency is keyed off entry.IdempotencyKey, but only purchase's
+// stripeBackend actually threads it through to the pro-server (via
+// client.Purchase.IdempotencyKey) - go-client's cancel/referral/
+// removedevice RPCs have no idempotency-key parameter to give it to. Those
+// three are safe to retry anyway because they're idempotent by nature of
+// what they do: canceling an already-canceled subscription, redeeming an
+// already-used referral code, or removing an already-removed device all
+// just come back as a no-op or a terminal server error, not a duplicate
+// side effect.
+var mutatingCommands = map[string]proFunc{
+	"purchase": purchase,
+	"cancel":   cancel,
+	"referral": referral,
+}
+
+// enqueueMutatingRequest durably records a mutating pro-server request and
+// starts a background worker that retries it with exponential backoff and
+// jitter until the pro-server returns a terminal status, then reports the
+// outcome via Session.OnRequestCompleted. It returns true once the request
+// has been persisted, not once it has completed.
+func enqueueMutatingRequest(shouldProxy bool, command string, session Session, run proFunc) bool {
+	entry := &outboxEntry{
+		Id:             newOutboxId(),
+		Command:        command,
+		IdempotencyKey: newOutboxId(),
+	}
+
+	ob := newOutbox(session.ConfigDir())
+	if err := ob.add(entry); err != nil {
+		log.Errorf("Could not persist outbox entry for %s: %v", command, err)
+		return false
+	}
+
+	go ob.worker(shouldProxy, session, entry, run)
+
+	return true
+}
+
+// ResumeOutbox relaunches a worker for every entry left in session's
+// outbox from a previous process - enqueueMutatingRequest's worker
+// goroutine only lives as long as the process that started it, so without
+// this call a purchase/cancel/referral queued just before the app was
+// killed (routine on mobile while backgrounded or tunnel-down) would sit
+// in pro-outbox.json forever instead of ever being retried. Callers should
+// invoke this once at startup, after constructing their Session.
+//
+// Only entries whose command is in mutatingCommands are resumed. Any other
+// entry is removed without a retry or an OnRequestCompleted callback; that
+// includes removedevice entries queued by RemoveDevice, since the outbox
+// does not store their deviceId.
+func ResumeOutbox(shouldProxy bool, session Session) {
+	ob := newOutbox(session.ConfigDir())
+	entries, err := ob.load()
+	if err != nil {
+		log.Errorf("Could not load outbox to resume: %v", err)
+		return
+	}
+	for _, entry := range entries {
+		run, ok := mutatingCommands[entry.Command]
+		if !ok {
+			log.Errorf("Dropping orphaned outbox entry %s with unknown command %s", entry.Id, entry.Command)
+			ob.remove(entry.Id)
+			continue
+		}
+		log.Debugf("Resuming queued %s request %s (%d prior attempts)", entry.Command, entry.Id, entry.Attempts)
+		go ob.worker(shouldProxy, session, entry, run)
+	}
+}
+
+func (o *outbox) worker(shouldProxy bool, session Session, entry *outboxEntry, run proFunc) {
+	backoff := time.Second
+	for {
+		ok, retry, err := o.attempt(shouldProxy, session, entry, run)
+		if ok {
+			o.remove(entry.Id)
+			session.OnRequestCompleted(entry.Id, true, nil)
+			return
+		}
+		if !retry {
+			o.remove(entry.Id)
+			session.OnRequestCompleted(entry.Id, false, err)
+			return
+		}
+
+		o.recordAttempt(entry.Id)
+		entry.Attempts++
+		log.Debugf("Retrying queued %s request %s in %v (attempt %d): %v",
+			entry.Command, entry.Id, backoff, entry.Attempts+1, err)
+		time.Sleep(withJitter(backoff))
+		backoff = nextBackoff(backoff)
+	}
+}
+
+// attempt runs one dispatch of a queued request. ok means it reached a
+// terminal success; retry means the failure looks transient (a network
+// problem) and is worth retrying; otherwise the failure is terminal and the
+// request should be dropped from the queue.
+func (o *outbox) attempt(shouldProxy bool, session Session, entry *outboxEntry, run proFunc) (ok bool, retry bool, err error) {
+	req, derr := newRequest(shouldProxy, session)
+	if derr != nil {
+		return false, true, derr
+	}
+	req.session = session
+	req.proClient.SetLocale(session.Locale())
+	req.idempotencyKey = entry.IdempotencyKey
+
+	res, rerr := run(req)
+	cerr := classifyError(entry.Command, res, rerr)
+	if cerr == nil {
+		return true, false, nil
+	}
+	if errors.Is(cerr, ErrNetwork) || errors.Is(cerr, ErrPending) {
+		return false, true, cerr
+	}
+	return false, false, cerr
+}
+
+func nextBackoff(d time.Duration) time.Duration {
+	d *= 2
+	if d > outboxMaxBackoff {
+		d = outboxMaxBackoff
+	}
+	return d
+}
+
+func withJitter(d time.Duration) time.Duration {
+	return d + time.Duration(rand.Int63n(int64(d)/5+1))
+}
+
+func newOutboxId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return fmt.Sprintf("%x-%d", b, time.Now().UnixNano())
+}
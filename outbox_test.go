@@ -0,0 +1,163 @@
+package lantern
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/getlantern/pro-server-client/go-client"
+)
+
+// testSession adapts BaseSession into a full Session by supplying the
+// callbacks BaseSession leaves to platform bindings; tests don't care
+// about any of them beyond ConfigDir.
+type testSession struct {
+	*BaseSession
+}
+
+func (testSession) AddPlan(string, string, string, bool, int, int)                           {}
+func (testSession) AddDevice(string, string)                                                 {}
+func (testSession) SetError(string, string)                                                  {}
+func (testSession) ShowLinkCode(code, verificationURI string, expiresAt int64, interval int) {}
+func (testSession) OnRequestCompleted(id string, ok bool, err error)                         {}
+
+func newTestSession(t *testing.T) Session {
+	return testSession{NewBaseSession(t.TempDir())}
+}
+
+func TestOutboxRoundTrip(t *testing.T) {
+	ob := newOutbox(t.TempDir())
+
+	entry := &outboxEntry{Id: "abc", Command: "purchase", IdempotencyKey: "key-1"}
+	if err := ob.add(entry); err != nil {
+		t.Fatalf("add() = %v", err)
+	}
+
+	entries, err := ob.load()
+	if err != nil {
+		t.Fatalf("load() = %v", err)
+	}
+	if got, ok := entries["abc"]; !ok || got.Command != "purchase" || got.IdempotencyKey != "key-1" {
+		t.Fatalf("load() = %+v, want the entry just added", entries)
+	}
+
+	ob.recordAttempt("abc")
+	entries, err = ob.load()
+	if err != nil {
+		t.Fatalf("load() after recordAttempt = %v", err)
+	}
+	if entries["abc"].Attempts != 1 {
+		t.Fatalf("Attempts = %d, want 1", entries["abc"].Attempts)
+	}
+
+	ob.remove("abc")
+	entries, err = ob.load()
+	if err != nil {
+		t.Fatalf("load() after remove = %v", err)
+	}
+	if _, ok := entries["abc"]; ok {
+		t.Fatalf("entry %q still present after remove()", "abc")
+	}
+}
+
+func TestOutboxLoadMissingFileIsEmpty(t *testing.T) {
+	ob := newOutbox(t.TempDir())
+	entries, err := ob.load()
+	if err != nil {
+		t.Fatalf("load() = %v, want nil error for a missing file", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("load() = %+v, want empty", entries)
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	tests := []struct {
+		in   time.Duration
+		want time.Duration
+	}{
+		{time.Second, 2 * time.Second},
+		{time.Minute, 2 * time.Minute},
+		{outboxMaxBackoff, outboxMaxBackoff},
+		{outboxMaxBackoff / 2 * 3, outboxMaxBackoff},
+	}
+	for _, tt := range tests {
+		if got := nextBackoff(tt.in); got != tt.want {
+			t.Errorf("nextBackoff(%v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestOutboxAttempt(t *testing.T) {
+	session := newTestSession(t)
+	entry := &outboxEntry{Id: "abc", Command: "purchase", IdempotencyKey: "key-1"}
+
+	tests := []struct {
+		name      string
+		run       proFunc
+		wantOk    bool
+		wantRetry bool
+	}{
+		{
+			name:   "success is terminal and ok",
+			run:    func(*proRequest) (*client.Response, error) { return &client.Response{Status: "ok"}, nil },
+			wantOk: true,
+		},
+		{
+			name:      "network error is retryable",
+			run:       func(*proRequest) (*client.Response, error) { return nil, errors.New("dial tcp: timeout") },
+			wantRetry: true,
+		},
+		{
+			name: "pending is retryable",
+			run: func(*proRequest) (*client.Response, error) {
+				return &client.Response{Status: "error", Error: "authorization_pending"}, nil
+			},
+			wantRetry: true,
+		},
+		{
+			name: "server error is terminal and not ok",
+			run: func(*proRequest) (*client.Response, error) {
+				return &client.Response{Status: "error", Error: "payment_declined"}, nil
+			},
+		},
+	}
+
+	ob := newOutbox(t.TempDir())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, retry, err := ob.attempt(false, session, entry, tt.run)
+			if ok != tt.wantOk {
+				t.Errorf("ok = %v, want %v", ok, tt.wantOk)
+			}
+			if retry != tt.wantRetry {
+				t.Errorf("retry = %v, want %v", retry, tt.wantRetry)
+			}
+			if !tt.wantOk && err == nil {
+				t.Errorf("err = nil, want non-nil for a failed attempt")
+			}
+		})
+	}
+}
+
+func TestResumeOutboxDropsUnknownCommand(t *testing.T) {
+	configDir := t.TempDir()
+	ob := newOutbox(configDir)
+	entry := &outboxEntry{Id: "orphan", Command: "not-a-real-command"}
+	if err := ob.add(entry); err != nil {
+		t.Fatalf("add() = %v", err)
+	}
+
+	session := testSession{NewBaseSession(configDir)}
+	ResumeOutbox(false, session)
+
+	// ResumeOutbox drops unknown commands synchronously, before spawning
+	// any worker, so there's no race to wait out here.
+	entries, err := ob.load()
+	if err != nil {
+		t.Fatalf("load() = %v", err)
+	}
+	if _, ok := entries["orphan"]; ok {
+		t.Fatalf("orphaned entry with an unknown command was not dropped")
+	}
+}
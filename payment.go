@@ -0,0 +1,173 @@
+package lantern
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/getlantern/pro-server-client/go-client"
+	"github.com/stripe/stripe-go"
+)
+
+// PaymentProvider identifies the billing backend that should handle a
+// purchase request. Mobile clients select one via Session.PaymentProvider()
+// so that platform-native receipts never need to be shoehorned into
+// Stripe-specific fields.
+//
+// Only ProviderStripe is wired up end to end. ProviderGooglePlay/
+// ProviderAppleAppStore/ProviderBTCPay exist as real dispatch targets -
+// PaymentPayload() plumbing, payload validation, their own paymentBackend
+// - but their purchase() always returns ErrProviderNotImplemented, because
+// go-client has no receipt/invoice-validation RPC for any of them yet.
+// Selecting one of those three is a guaranteed failure until that
+// server-side work lands; track turning each on as its own follow-up once
+// go-client exposes the corresponding endpoint, rather than assuming this
+// scaffolding already means Google Play/Apple/BTCPay support shipped.
+type PaymentProvider int
+
+const (
+	// ProviderStripe is the original web/desktop checkout flow.
+	ProviderStripe PaymentProvider = iota
+	// ProviderGooglePlay validates a Google Play Billing purchase token.
+	ProviderGooglePlay
+	// ProviderAppleAppStore validates an App Store receipt.
+	ProviderAppleAppStore
+	// ProviderBTCPay settles an invoice through a BTCPay Server instance.
+	ProviderBTCPay
+)
+
+func (p PaymentProvider) String() string {
+	switch p {
+	case ProviderStripe:
+		return "stripe"
+	case ProviderGooglePlay:
+		return "google-play"
+	case ProviderAppleAppStore:
+		return "apple-app-store"
+	case ProviderBTCPay:
+		return "btcpay"
+	default:
+		return "unknown"
+	}
+}
+
+// paymentBackend encapsulates everything a single payment provider needs in
+// order to turn a Session into a purchase call against the pro-server: its
+// own idempotency key, its own receipt/payload fields, and its own error
+// mapping.
+type paymentBackend interface {
+	purchase(r *proRequest) (*client.Response, error)
+}
+
+var paymentBackends = map[PaymentProvider]paymentBackend{
+	ProviderStripe:        stripeBackend{},
+	ProviderGooglePlay:    googlePlayBackend{},
+	ProviderAppleAppStore: appleAppStoreBackend{},
+	ProviderBTCPay:        btcPayBackend{},
+}
+
+// stripeBackend is the original checkout flow, unchanged in behavior from
+// before PaymentProvider existed.
+type stripeBackend struct{}
+
+func (stripeBackend) purchase(r *proRequest) (*client.Response, error) {
+	key := r.idempotencyKey
+	if key == "" {
+		key = stripe.NewIdempotencyKey()
+	}
+
+	purchase := client.Purchase{
+		IdempotencyKey: key,
+		StripeToken:    r.session.StripeToken(),
+		StripeEmail:    r.session.Email(),
+		Plan:           r.session.Plan(),
+		Currency:       strings.ToLower(r.session.Currency()),
+	}
+
+	return r.proClient.Purchase(r.user, r.session.DeviceName(), r.session.StripeApiKey(), purchase)
+}
+
+// ErrProviderNotImplemented is returned by a payment backend whose
+// server-side receipt-validation endpoint doesn't exist in go-client yet.
+// It must never be papered over by routing the receipt through
+// r.proClient.Purchase: that RPC is Stripe-specific (it charges a
+// StripeToken), and stuffing a different provider's receipt into that
+// field would either be rejected server-side or, worse, silently accepted
+// as an opaque string with no real validation, granting entitlements on an
+// unverified receipt.
+var ErrProviderNotImplemented = errors.New("pro-server: payment provider not yet implemented")
+
+// googlePlayBackend checks that the payload carries a Google Play Billing
+// purchase token and product id; it does not validate them, and its purchase
+// always returns ErrProviderNotImplemented.
+type googlePlayBackend struct{}
+
+func (googlePlayBackend) purchase(r *proRequest) (*client.Response, error) {
+	payload := r.session.PaymentPayload()
+	purchaseToken := payload["purchaseToken"]
+	productId := payload["productId"]
+	if purchaseToken == "" || productId == "" {
+		log.Errorf("Google Play purchase requested without a purchaseToken/productId")
+		if purchaseToken == "" {
+			return nil, errPaymentPayloadMissing("purchaseToken")
+		}
+		return nil, errPaymentPayloadMissing("productId")
+	}
+
+	// TODO(lanternlib): call the pro-server's Google Play Billing
+	// receipt-validation endpoint (e.g. a future
+	// client.Client.ValidateGooglePlayPurchase(r.user, purchaseToken,
+	// productId)) once go-client exposes one.
+	log.Errorf("Google Play purchase validation is not implemented in go-client yet")
+	return nil, fmt.Errorf("%w: google play", ErrProviderNotImplemented)
+}
+
+// appleAppStoreBackend checks that the payload carries App Store receipt
+// data; it does not validate the receipt, and its purchase always returns
+// ErrProviderNotImplemented.
+type appleAppStoreBackend struct{}
+
+func (appleAppStoreBackend) purchase(r *proRequest) (*client.Response, error) {
+	payload := r.session.PaymentPayload()
+	receipt := payload["receiptData"]
+	if receipt == "" {
+		log.Errorf("Apple App Store purchase requested without receiptData")
+		return nil, errPaymentPayloadMissing("receiptData")
+	}
+
+	// TODO(lanternlib): call the pro-server's App Store receipt-validation
+	// endpoint (e.g. a future client.Client.ValidateAppleReceipt(r.user,
+	// receipt)) once go-client exposes one.
+	log.Errorf("Apple App Store receipt validation is not implemented in go-client yet")
+	return nil, fmt.Errorf("%w: apple app store", ErrProviderNotImplemented)
+}
+
+// btcPayBackend checks that the payload carries a BTCPay Server invoice id;
+// it does not settle or check the invoice, and its purchase always returns
+// ErrProviderNotImplemented.
+type btcPayBackend struct{}
+
+func (btcPayBackend) purchase(r *proRequest) (*client.Response, error) {
+	payload := r.session.PaymentPayload()
+	invoiceId := payload["invoiceId"]
+	if invoiceId == "" {
+		log.Errorf("BTCPay purchase requested without an invoiceId")
+		return nil, errPaymentPayloadMissing("invoiceId")
+	}
+
+	// TODO(lanternlib): call the pro-server's BTCPay invoice-status
+	// endpoint (e.g. a future client.Client.ValidateBTCPayInvoice(r.user,
+	// invoiceId)) once go-client exposes one.
+	log.Errorf("BTCPay invoice validation is not implemented in go-client yet")
+	return nil, fmt.Errorf("%w: btcpay", ErrProviderNotImplemented)
+}
+
+// ErrInvalidPayload is returned when Session.PaymentPayload() is missing a
+// field a provider needs. Like ErrProviderNotImplemented, it's a
+// client-side rejection, not a network failure, and must not be retried by
+// the outbox.
+var ErrInvalidPayload = errors.New("pro-server: invalid payment payload")
+
+func errPaymentPayloadMissing(field string) error {
+	return fmt.Errorf("%w: missing required field %q", ErrInvalidPayload, field)
+}
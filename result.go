@@ -0,0 +1,198 @@
+package lantern
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/getlantern/pro-server-client/go-client"
+)
+
+// Typed errors returned by the Purchase/Plans/GetUserData APIs. Callers can
+// errors.Is against these to decide whether a retry, a re-auth prompt, or a
+// hard failure is appropriate, instead of parsing a bool and a Session
+// side-effect.
+var (
+	ErrAuthRequired    = errors.New("pro-server: authentication required")
+	ErrPaymentDeclined = errors.New("pro-server: payment declined")
+	ErrNetwork         = errors.New("pro-server: network error")
+	// ErrPending indicates the pro-server hasn't reached a terminal
+	// decision yet - e.g. "authorization_pending" or "slow_down" while a
+	// device-link code is still waiting to be completed on another
+	// device. Like ErrNetwork, it's worth retrying; unlike
+	// ErrAuthRequired/ErrPaymentDeclined/ServerError, it isn't a failure.
+	ErrPending = errors.New("pro-server: request still pending")
+)
+
+// ServerError wraps a non-"ok" status returned by the pro-server that
+// doesn't map to one of the sentinel errors above, preserving the command
+// and server-provided error code for callers that need to branch on it.
+type ServerError struct {
+	Command string
+	Code    string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("pro-server: %s failed: %s", e.Command, e.Code)
+}
+
+// PurchaseResult is the outcome of a successful Purchase call.
+type PurchaseResult struct {
+	Status string
+}
+
+// Plan mirrors a single plan offered by the pro-server.
+type Plan struct {
+	Id          string
+	Description string
+	Currency    string
+	BestValue   bool
+	Years       int
+	Price       int
+}
+
+// PlansResult is the outcome of a successful Plans call: the plans on
+// offer, plus the Stripe publishable key they should be purchased with.
+type PlansResult struct {
+	StripePubKey string
+	Plans        []Plan
+}
+
+// Device mirrors a single device linked to a pro user.
+type Device struct {
+	Id   string
+	Name string
+}
+
+// UserData is the outcome of a successful GetUserData call.
+type UserData struct {
+	Status       string
+	Expiration   int64
+	Subscription string
+	Email        string
+	Devices      []Device
+}
+
+// classifyError turns a (*client.Response, error) pair from a proFunc into
+// one of the typed errors above, or nil if the request succeeded.
+func classifyError(command string, res *client.Response, err error) error {
+	if err != nil {
+		// A payment backend can reject a request before it ever reaches
+		// the pro-server (an unimplemented provider, a malformed
+		// payload); that's already a typed, terminal error and must not
+		// be relabeled as a retryable network failure.
+		if errors.Is(err, ErrProviderNotImplemented) || errors.Is(err, ErrInvalidPayload) {
+			return err
+		}
+		return fmt.Errorf("%w: %v", ErrNetwork, err)
+	}
+	if res.Status != "ok" {
+		switch res.Error {
+		case "auth_required", "invalid_token":
+			return ErrAuthRequired
+		case "payment_declined", "card_declined":
+			return ErrPaymentDeclined
+		case "authorization_pending", "slow_down":
+			return fmt.Errorf("%w: %s", ErrPending, res.Error)
+		default:
+			return &ServerError{Command: command, Code: res.Error}
+		}
+	}
+	return nil
+}
+
+// Purchase charges the session's configured payment provider for the plan
+// set on the session, returning a typed result instead of communicating
+// through Session side effects.
+func Purchase(ctx context.Context, shouldProxy bool, session Session) (*PurchaseResult, error) {
+	req, err := dial(ctx, shouldProxy, session)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := purchase(req)
+	if cerr := classifyError("purchase", res, err); cerr != nil {
+		return nil, cerr
+	}
+
+	return &PurchaseResult{Status: res.Status}, nil
+}
+
+// Plans fetches the plans the pro-server is currently offering.
+func Plans(ctx context.Context, shouldProxy bool, session Session) (*PlansResult, error) {
+	req, err := dial(ctx, shouldProxy, session)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := plans(req)
+	if cerr := classifyError("plans", res, err); cerr != nil {
+		return nil, cerr
+	}
+
+	result := &PlansResult{StripePubKey: res.PubKey, Plans: make([]Plan, 0, len(res.Plans))}
+	for _, plan := range res.Plans {
+		var currency string
+		var price int
+		for currency, price = range plan.Price {
+			break
+		}
+		if currency == "" {
+			continue
+		}
+		result.Plans = append(result.Plans, Plan{
+			Id:          plan.Id,
+			Description: plan.Description,
+			Currency:    currency,
+			BestValue:   plan.BestValue,
+			Years:       plan.Duration.Years,
+			Price:       price,
+		})
+	}
+
+	return result, nil
+}
+
+// GetUserData fetches the current user's subscription status and devices.
+func GetUserData(ctx context.Context, shouldProxy bool, session Session) (*UserData, error) {
+	req, err := dial(ctx, shouldProxy, session)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := userdata(req)
+	if cerr := classifyError("userdata", res, err); cerr != nil {
+		return nil, cerr
+	}
+
+	devices := make([]Device, 0, len(res.User.Devices))
+	for _, device := range res.User.Devices {
+		devices = append(devices, Device{Id: device.Id, Name: device.Name})
+	}
+
+	return &UserData{
+		Status:       res.User.UserStatus,
+		Expiration:   res.User.Expiration,
+		Subscription: res.User.Subscription,
+		Email:        res.User.Email,
+		Devices:      devices,
+	}, nil
+}
+
+// dial builds a proRequest and bails out early if ctx has already been
+// canceled, so callers get a prompt error instead of an in-flight request
+// they no longer care about.
+func dial(ctx context.Context, shouldProxy bool, session Session) (*proRequest, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNetwork, err)
+	}
+
+	req, err := newRequest(shouldProxy, session)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNetwork, err)
+	}
+	req.session = session
+	req.proClient.SetLocale(session.Locale())
+
+	return req, nil
+}
@@ -0,0 +1,105 @@
+package lantern
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/getlantern/pro-server-client/go-client"
+)
+
+func TestClassifyError(t *testing.T) {
+	someErr := errors.New("boom")
+
+	tests := []struct {
+		name    string
+		res     *client.Response
+		err     error
+		wantNil bool
+		wantIs  error
+		wantAs  *ServerError
+	}{
+		{
+			name:    "success",
+			res:     &client.Response{Status: "ok"},
+			wantNil: true,
+		},
+		{
+			name:   "generic error is wrapped as network",
+			err:    someErr,
+			wantIs: ErrNetwork,
+		},
+		{
+			name:   "provider not implemented passes through unwrapped",
+			err:    fmt.Errorf("%w: google play", ErrProviderNotImplemented),
+			wantIs: ErrProviderNotImplemented,
+		},
+		{
+			name:   "invalid payload passes through unwrapped",
+			err:    fmt.Errorf("%w: missing required field %q", ErrInvalidPayload, "purchaseToken"),
+			wantIs: ErrInvalidPayload,
+		},
+		{
+			name:   "auth_required",
+			res:    &client.Response{Status: "error", Error: "auth_required"},
+			wantIs: ErrAuthRequired,
+		},
+		{
+			name:   "invalid_token",
+			res:    &client.Response{Status: "error", Error: "invalid_token"},
+			wantIs: ErrAuthRequired,
+		},
+		{
+			name:   "payment_declined",
+			res:    &client.Response{Status: "error", Error: "payment_declined"},
+			wantIs: ErrPaymentDeclined,
+		},
+		{
+			name:   "card_declined",
+			res:    &client.Response{Status: "error", Error: "card_declined"},
+			wantIs: ErrPaymentDeclined,
+		},
+		{
+			name:   "authorization_pending",
+			res:    &client.Response{Status: "error", Error: "authorization_pending"},
+			wantIs: ErrPending,
+		},
+		{
+			name:   "slow_down",
+			res:    &client.Response{Status: "error", Error: "slow_down"},
+			wantIs: ErrPending,
+		},
+		{
+			name:   "unknown code becomes a ServerError",
+			res:    &client.Response{Status: "error", Error: "something_else"},
+			wantAs: &ServerError{Command: "purchase", Code: "something_else"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classifyError("purchase", tt.res, tt.err)
+			if tt.wantNil {
+				if got != nil {
+					t.Fatalf("classifyError() = %v, want nil", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatalf("classifyError() = nil, want non-nil")
+			}
+			if tt.wantIs != nil && !errors.Is(got, tt.wantIs) {
+				t.Fatalf("classifyError() = %v, want errors.Is(%v)", got, tt.wantIs)
+			}
+			if tt.wantAs != nil {
+				var serverErr *ServerError
+				if !errors.As(got, &serverErr) {
+					t.Fatalf("classifyError() = %v, want *ServerError", got)
+				}
+				if *serverErr != *tt.wantAs {
+					t.Fatalf("classifyError() = %+v, want %+v", serverErr, tt.wantAs)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package lantern
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// BaseSession is a concrete, storage-backed Session. Platform bindings
+// embed it instead of re-implementing every getter and setter by hand, and
+// only need to supply the callbacks that have no sensible generic default:
+//
+//	AddPlan(id, description, currency string, bestValue bool, years, price int)
+//	AddDevice(id, name string)
+//	SetError(command, code string)
+//	ShowLinkCode(code, verificationURI string, expiresAt int64, interval int)
+//	OnRequestCompleted(id string, ok bool, err error)
+//
+// Everything else is persisted in an on-disk key/value store (see
+// store.go). Token and StripeToken go through a secretStore instead (see
+// keychain_*.go); use NewBaseSessionWithSecretKey, not NewBaseSession, if
+// they need real OS-keychain protection.
+type BaseSession struct {
+	configDir string
+	kv        kvStore
+	secrets   secretStore
+}
+
+// NewBaseSession opens (or creates) the stores BaseSession keeps under
+// configDir. Token/StripeToken fall back to the same locally-generated,
+// locally-stored key as the rest of the store (see sessionKeyFileName),
+// which only obfuscates them against a casual read of session.secrets -
+// it is not a substitute for OS keychain protection. Platform bindings
+// that can source a secret from the real iOS Keychain or Android Keystore
+// should call NewBaseSessionWithSecretKey instead.
+func NewBaseSession(configDir string) *BaseSession {
+	key := loadOrCreateKey(configDir)
+	return &BaseSession{
+		configDir: configDir,
+		kv:        newFileStore(configDir, sessionStoreFileName, key),
+		secrets:   newSecretStore(configDir, key),
+	}
+}
+
+// NewBaseSessionWithSecretKey is NewBaseSession, except Token/StripeToken
+// are encrypted with a key derived from secretKey instead of the
+// locally-generated one. secretKey should come from the platform's real
+// OS keychain/keystore (e.g. an iOS Keychain item or an Android Keystore-
+// wrapped key) - something this pure-Go package has no cgo/JNI bridge to
+// reach itself (see keychain_*.go), but that a native binding calling
+// into this package does have access to.
+func NewBaseSessionWithSecretKey(configDir string, secretKey []byte) *BaseSession {
+	return &BaseSession{
+		configDir: configDir,
+		kv:        newFileStore(configDir, sessionStoreFileName, loadOrCreateKey(configDir)),
+		secrets:   newFileSecretStore(configDir, deriveSecretKey(secretKey)),
+	}
+}
+
+func (s *BaseSession) ConfigDir() string { return s.configDir }
+
+func (s *BaseSession) UserId() int {
+	id, _ := strconv.Atoi(s.kv.get("userId"))
+	return id
+}
+func (s *BaseSession) SetUserId(id int) { s.kv.set("userId", strconv.Itoa(id)) }
+
+func (s *BaseSession) Code() string        { return s.kv.get("code") }
+func (s *BaseSession) SetCode(code string) { s.kv.set("code", code) }
+
+func (s *BaseSession) VerifyCode() string        { return s.kv.get("verifyCode") }
+func (s *BaseSession) SetVerifyCode(code string) { s.kv.set("verifyCode", code) }
+
+func (s *BaseSession) DeviceCode() string { return s.kv.get("deviceCode") }
+func (s *BaseSession) SetDeviceCode(code string, expiresAt int64) {
+	s.kv.set("deviceCode", code)
+	s.kv.set("deviceCodeExpiresAt", strconv.FormatInt(expiresAt, 10))
+}
+
+func (s *BaseSession) DeviceId() string      { return s.kv.get("deviceId") }
+func (s *BaseSession) SetDeviceId(id string) { s.kv.set("deviceId", id) }
+
+func (s *BaseSession) DeviceName() string        { return s.kv.get("deviceName") }
+func (s *BaseSession) SetDeviceName(name string) { s.kv.set("deviceName", name) }
+
+func (s *BaseSession) Locale() string          { return s.kv.get("locale") }
+func (s *BaseSession) SetLocale(locale string) { s.kv.set("locale", locale) }
+
+func (s *BaseSession) Referral() string            { return s.kv.get("referral") }
+func (s *BaseSession) SetReferral(referral string) { s.kv.set("referral", referral) }
+
+func (s *BaseSession) Token() string         { return s.secrets.getSecret("token") }
+func (s *BaseSession) SetToken(token string) { s.secrets.setSecret("token", token) }
+
+func (s *BaseSession) Plan() string        { return s.kv.get("plan") }
+func (s *BaseSession) SetPlan(plan string) { s.kv.set("plan", plan) }
+
+func (s *BaseSession) StripeToken() string         { return s.secrets.getSecret("stripeToken") }
+func (s *BaseSession) SetStripeToken(token string) { s.secrets.setSecret("stripeToken", token) }
+
+func (s *BaseSession) StripeApiKey() string       { return s.kv.get("stripePubKey") }
+func (s *BaseSession) SetStripePubKey(key string) { s.kv.set("stripePubKey", key) }
+
+func (s *BaseSession) Email() string         { return s.kv.get("email") }
+func (s *BaseSession) SetEmail(email string) { s.kv.set("email", email) }
+
+func (s *BaseSession) Currency() string            { return s.kv.get("currency") }
+func (s *BaseSession) SetCurrency(currency string) { s.kv.set("currency", currency) }
+
+func (s *BaseSession) UserData(status string, expiration int64, subscription string, email string) {
+	s.kv.set("userStatus", status)
+	s.kv.set("expiration", strconv.FormatInt(expiration, 10))
+	s.kv.set("subscription", subscription)
+	s.SetEmail(email)
+}
+
+func (s *BaseSession) PaymentProvider() PaymentProvider {
+	n, _ := strconv.Atoi(s.kv.get("paymentProvider"))
+	return PaymentProvider(n)
+}
+func (s *BaseSession) SetPaymentProvider(provider PaymentProvider) {
+	s.kv.set("paymentProvider", strconv.Itoa(int(provider)))
+}
+
+func (s *BaseSession) PaymentPayload() map[string]string {
+	payload := make(map[string]string)
+	raw := s.kv.get("paymentPayload")
+	if raw == "" {
+		return payload
+	}
+	if err := json.Unmarshal([]byte(raw), &payload); err != nil {
+		log.Errorf("Could not parse stored payment payload: %v", err)
+	}
+	return payload
+}
+func (s *BaseSession) SetPaymentPayload(payload map[string]string) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Errorf("Could not store payment payload: %v", err)
+		return
+	}
+	s.kv.set("paymentPayload", string(data))
+}
@@ -0,0 +1,206 @@
+package lantern
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// sessionStoreFileName is the encrypted key/value file BaseSession persists
+// its non-sensitive scalar fields to, inside the session's config dir.
+const sessionStoreFileName = "session.store"
+
+// secretStoreFileName is the encrypted key/value file the default
+// secretStore fallback persists Token and StripeToken to, when no
+// build-tagged keychain/keystore backend is available (see keychain_*.go).
+const secretStoreFileName = "session.secrets"
+
+// sessionKeyFileName holds the AES-256 key used to encrypt session.store,
+// and secretStore falls back to it too when no secret key has been
+// injected (see newFileSecretStore/NewBaseSessionWithSecretKey).
+//
+// This key sits in the same directory, with the same permissions, as the
+// ciphertext it decrypts, so it provides no confidentiality against
+// anything that can already read the app's storage (a device backup, a
+// rooted/jailbroken extraction, another process with storage access) -
+// only against a cursory look at session.store/session.secrets in
+// isolation. Real protection for Token/StripeToken requires deriving
+// their key from something the OS actually guards, which is what
+// NewBaseSessionWithSecretKey is for.
+const sessionKeyFileName = "session.key"
+
+// kvStore is the minimal persistence BaseSession needs: a flat string
+// key/value store.
+type kvStore interface {
+	get(key string) string
+	set(key, value string)
+}
+
+// secretStore is a kvStore for the handful of Session fields sensitive
+// enough to deserve OS-keychain treatment where one is available. This
+// package has no cgo/JNI bridge to the iOS Keychain or Android Keystore
+// (see keychain_*.go), so it cannot fetch or guard that key itself; a
+// platform binding that wants real protection must read the key from its
+// native keychain/keystore and hand it to NewBaseSessionWithSecretKey.
+// Without that, secretStore falls back to the same scheme as the rest of
+// BaseSession's storage, which is obfuscation, not encryption with any
+// confidentiality guarantee - see sessionKeyFileName.
+type secretStore interface {
+	getSecret(key string) string
+	setSecret(key, value string)
+}
+
+// fileStore is an AES-GCM-encrypted JSON file on disk. It's the default
+// kvStore, and also backs secretStore on platforms without a dedicated
+// keychain/keystore (see keychain_other.go).
+type fileStore struct {
+	mu     sync.Mutex
+	path   string
+	gcm    cipher.AEAD
+	values map[string]string
+}
+
+func newFileStore(configDir, name string, key [32]byte) *fileStore {
+	s := &fileStore{
+		path:   filepath.Join(configDir, name),
+		values: make(map[string]string),
+	}
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		log.Errorf("Could not initialize session store cipher: %v", err)
+		return s
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		log.Errorf("Could not initialize session store cipher: %v", err)
+		return s
+	}
+	s.gcm = gcm
+	s.load()
+	return s
+}
+
+func (s *fileStore) load() {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Errorf("Could not read session store %s: %v", s.path, err)
+		return
+	}
+	plaintext, err := s.decrypt(data)
+	if err != nil {
+		log.Errorf("Could not decrypt session store %s: %v", s.path, err)
+		return
+	}
+	if err := json.Unmarshal(plaintext, &s.values); err != nil {
+		log.Errorf("Could not parse session store %s: %v", s.path, err)
+	}
+}
+
+func (s *fileStore) persist() {
+	data, err := json.Marshal(s.values)
+	if err != nil {
+		log.Errorf("Could not serialize session store %s: %v", s.path, err)
+		return
+	}
+	ciphertext, err := s.encrypt(data)
+	if err != nil {
+		log.Errorf("Could not encrypt session store %s: %v", s.path, err)
+		return
+	}
+	if err := ioutil.WriteFile(s.path, ciphertext, 0600); err != nil {
+		log.Errorf("Could not write session store %s: %v", s.path, err)
+	}
+}
+
+func (s *fileStore) encrypt(plaintext []byte) ([]byte, error) {
+	if s.gcm == nil {
+		return nil, errors.New("session store cipher not initialized")
+	}
+	nonce := make([]byte, s.gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return s.gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (s *fileStore) decrypt(ciphertext []byte) ([]byte, error) {
+	if s.gcm == nil {
+		return nil, errors.New("session store cipher not initialized")
+	}
+	nonceSize := s.gcm.NonceSize()
+	if len(ciphertext) < nonceSize {
+		return nil, errors.New("session store file is corrupt")
+	}
+	nonce, sealed := ciphertext[:nonceSize], ciphertext[nonceSize:]
+	return s.gcm.Open(nil, nonce, sealed, nil)
+}
+
+func (s *fileStore) get(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key]
+}
+
+func (s *fileStore) set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	s.persist()
+}
+
+// fileSecretStore adapts a fileStore to secretStore. It's what every
+// keychain_*.go backend falls back to when the platform doesn't (yet) have
+// a real keychain/keystore bridge wired up.
+type fileSecretStore struct {
+	*fileStore
+}
+
+func newFileSecretStore(configDir string, key [32]byte) secretStore {
+	return &fileSecretStore{newFileStore(configDir, secretStoreFileName, key)}
+}
+
+func (s *fileSecretStore) getSecret(key string) string { return s.get(key) }
+func (s *fileSecretStore) setSecret(key, value string) { s.set(key, value) }
+
+// deriveSecretKey turns a caller-supplied secret into the AES-256 key for
+// fileSecretStore. It's used when that secret came from an OS
+// keychain/keystore the native binding has real access to (this package
+// doesn't), so the resulting key is only as available as that keychain
+// entry is - unlike loadOrCreateKey's, it's never written to this
+// device's disk by this package at all.
+func deriveSecretKey(secret []byte) [32]byte {
+	return sha256.Sum256(secret)
+}
+
+// loadOrCreateKey returns the AES-256 key used to encrypt the session
+// stores in configDir, generating and persisting one on first use.
+func loadOrCreateKey(configDir string) [32]byte {
+	var key [32]byte
+
+	path := filepath.Join(configDir, sessionKeyFileName)
+	data, err := ioutil.ReadFile(path)
+	if err == nil && len(data) == len(key) {
+		copy(key[:], data)
+		return key
+	}
+
+	if _, err := rand.Read(key[:]); err != nil {
+		log.Errorf("Could not generate session store key: %v", err)
+		return key
+	}
+	if err := ioutil.WriteFile(path, key[:], 0600); err != nil {
+		log.Errorf("Could not persist session store key: %v", err)
+	}
+	return key
+}